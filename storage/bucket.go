@@ -0,0 +1,54 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	raw "code.google.com/p/google-api-go-client/storage/v1"
+	"golang.org/x/net/context"
+)
+
+// BucketHandle provides operations on a named bucket.
+// Use Client.Bucket to get a handle.
+type BucketHandle struct {
+	ctx  context.Context
+	name string
+}
+
+// NewBucketHandle creates a BucketHandle that refers to the named bucket.
+func NewBucketHandle(ctx context.Context, name string) *BucketHandle {
+	return &BucketHandle{ctx: ctx, name: name}
+}
+
+// BucketAttrsToUpdate describes how a bucket's attributes should be
+// modified by an Update call. Only non-nil fields are sent to GCS.
+type BucketAttrsToUpdate struct {
+	// Lifecycle, if non-nil, replaces the bucket's lifecycle
+	// configuration with the given one.
+	Lifecycle *Lifecycle
+}
+
+// Update applies the given changes to the bucket and returns the
+// bucket's attributes after the update.
+func (b *BucketHandle) Update(attrs BucketAttrsToUpdate) (*Bucket, error) {
+	rb := &raw.Bucket{}
+	if attrs.Lifecycle != nil {
+		rb.Lifecycle = toRawLifecycle(*attrs.Lifecycle)
+	}
+	resp, err := rawService(b.ctx).Buckets.Patch(b.name, rb).Do()
+	if err != nil {
+		return nil, err
+	}
+	return newBucket(resp), nil
+}
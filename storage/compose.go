@@ -0,0 +1,108 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+
+	raw "code.google.com/p/google-api-go-client/storage/v1"
+	"golang.org/x/net/context"
+)
+
+// maxComposeSources is the maximum number of source objects that a
+// single compose call can concatenate.
+const maxComposeSources = 32
+
+// ComposeSource identifies a single source object for a Compose call,
+// along with optional preconditions on its generation.
+type ComposeSource struct {
+	// Name is the name of the source object. It must be in the same
+	// bucket as the other sources and the destination.
+	Name string
+
+	// Generation, if non-zero, selects a specific generation of the
+	// source object instead of the latest one.
+	Generation int64
+
+	// IfGenerationMatch, if non-zero, makes the compose fail unless the
+	// source object's current generation matches this value.
+	IfGenerationMatch int64
+}
+
+// Compose concatenates the named source objects, all of which must be
+// in dst's bucket, into dst. Up to 32 sources may be composed in a
+// single call. The destination's metadata (ContentType, CacheControl,
+// ACL and Metadata) is taken from dst; its Name and Bucket identify
+// where the result is written. Each source's Generation, if non-zero,
+// selects a specific generation of that source instead of the latest
+// one. Sources that need an IfGenerationMatch precondition should use
+// ComposeWithPreconditions instead.
+func Compose(ctx context.Context, dst *Object, srcs []*Object) (*Object, error) {
+	csrcs := make([]*ComposeSource, len(srcs))
+	for i, s := range srcs {
+		if s == nil {
+			return nil, errors.New("storage: source object must not be nil")
+		}
+		csrcs[i] = &ComposeSource{Name: s.Name, Generation: s.Generation}
+	}
+	return composeSources(ctx, dst, csrcs)
+}
+
+// ComposeWithPreconditions is like Compose but takes a ComposeSource
+// per source object, letting the caller additionally require that a
+// source's generation matches IfGenerationMatch before it's used.
+func ComposeWithPreconditions(ctx context.Context, dst *Object, srcs []*ComposeSource) (*Object, error) {
+	return composeSources(ctx, dst, srcs)
+}
+
+func composeSources(ctx context.Context, dst *Object, srcs []*ComposeSource) (*Object, error) {
+	if dst == nil {
+		return nil, errors.New("storage: dst must not be nil")
+	}
+	if len(srcs) == 0 {
+		return nil, errors.New("storage: at least one source object is required")
+	}
+	if len(srcs) > maxComposeSources {
+		return nil, errors.New("storage: too many source objects")
+	}
+	if err := checkEncryptionKey(dst.EncryptionKey); err != nil {
+		return nil, err
+	}
+	req := &raw.ComposeRequest{
+		Destination: dst.toRawObject(),
+	}
+	for _, s := range srcs {
+		if s.Name == "" {
+			return nil, errors.New("storage: source object name must be non-empty")
+		}
+		req.SourceObjects = append(req.SourceObjects, &raw.ComposeRequestSourceObjects{
+			Name:       s.Name,
+			Generation: s.Generation,
+			ObjectPreconditions: &raw.ComposeRequestSourceObjectsObjectPreconditions{
+				IfGenerationMatch: s.IfGenerationMatch,
+			},
+		})
+	}
+	call := rawService(ctx).Objects.Compose(dst.Bucket, dst.Name, req)
+	setEncryptionHeaders(call.Header(), dst.EncryptionKey)
+	resp, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEncryptionResult(resp.CustomerEncryption, dst.EncryptionKey); err != nil {
+		return nil, err
+	}
+	return newObject(resp), nil
+}
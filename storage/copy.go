@@ -0,0 +1,142 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Copy copies the object named srcName in srcBucket to dst, which
+// identifies the destination bucket and name and supplies the
+// metadata (ContentType, CacheControl, ACL, Metadata, StorageClass)
+// to apply to the result. The copy happens server-side; object bytes
+// never pass through the client.
+//
+// Copy is a convenience wrapper around Rewriter: it repeats the
+// rewrite call until GCS reports it complete. It never sets
+// SourceEncryptionKey, so copying a source that was encrypted with a
+// customer-supplied key (even one equal to dst.EncryptionKey) needs
+// NewRewriter directly, with SourceEncryptionKey set.
+func Copy(ctx context.Context, srcBucket, srcName string, dst *Object) (*Object, error) {
+	rw := NewRewriter(ctx, srcBucket, srcName, dst)
+	for {
+		obj, done, err := rw.step()
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return obj, nil
+		}
+	}
+}
+
+// Rewriter copies an object to a (possibly different) destination,
+// looping over the GCS rewrite endpoint's continuation token until
+// the operation completes. Unlike a client-side download and
+// re-upload, a rewrite happens entirely within GCS and is the only
+// way to change the storage class or location of an object larger
+// than 5 GB.
+type Rewriter struct {
+	ctx context.Context
+
+	srcBucket, srcName string
+	dst                *Object
+
+	// SourceEncryptionKey is the 32-byte AES-256 customer-supplied key
+	// that was used to encrypt the source object. It's required
+	// whenever the source was written with one, even if dst uses the
+	// same key, since the source can't otherwise be read back to copy.
+	SourceEncryptionKey []byte
+
+	// MaxBytesRewrittenPerCall, if non-zero, bounds how many bytes a
+	// single rewrite call is allowed to copy before returning a
+	// continuation token. GCS may apply its own (larger) cap
+	// regardless of this value.
+	MaxBytesRewrittenPerCall int64
+
+	// TotalBytesRewritten is the number of bytes copied so far.
+	// Read-only; valid after at least one call to Do or step.
+	TotalBytesRewritten int64
+
+	// ObjectSize is the size in bytes of the source object being
+	// rewritten. Read-only; valid after at least one call to Do or
+	// step.
+	ObjectSize int64
+
+	token string
+	done  bool
+}
+
+// NewRewriter creates a Rewriter that will copy the object named
+// srcName in srcBucket to dst.
+func NewRewriter(ctx context.Context, srcBucket, srcName string, dst *Object) *Rewriter {
+	return &Rewriter{
+		ctx:       ctx,
+		srcBucket: srcBucket,
+		srcName:   srcName,
+		dst:       dst,
+	}
+}
+
+// Do calls the rewrite endpoint repeatedly until the rewrite
+// completes, returning the resulting object.
+func (r *Rewriter) Do() (*Object, error) {
+	for {
+		obj, done, err := r.step()
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return obj, nil
+		}
+	}
+}
+
+// step issues a single rewrite call, advancing r.token and the
+// progress counters. It returns the finished object and done=true
+// once GCS reports the rewrite complete.
+func (r *Rewriter) step() (obj *Object, done bool, err error) {
+	if err := checkEncryptionKey(r.dst.EncryptionKey); err != nil {
+		return nil, false, err
+	}
+	if err := checkEncryptionKey(r.SourceEncryptionKey); err != nil {
+		return nil, false, err
+	}
+	call := rawService(r.ctx).Objects.Rewrite(
+		r.srcBucket, r.srcName, r.dst.Bucket, r.dst.Name, r.dst.toRawObject())
+	if r.token != "" {
+		call = call.RewriteToken(r.token)
+	}
+	if r.MaxBytesRewrittenPerCall != 0 {
+		call = call.MaxBytesRewrittenPerCall(r.MaxBytesRewrittenPerCall)
+	}
+	setEncryptionHeaders(call.Header(), r.dst.EncryptionKey)
+	setSourceEncryptionHeaders(call.Header(), r.SourceEncryptionKey)
+
+	res, err := call.Do()
+	if err != nil {
+		return nil, false, err
+	}
+	r.TotalBytesRewritten = res.TotalBytesRewritten
+	r.ObjectSize = res.ObjectSize
+	if !res.Done {
+		r.token = res.RewriteToken
+		return nil, false, nil
+	}
+	if err := checkEncryptionResult(res.Resource.CustomerEncryption, r.dst.EncryptionKey); err != nil {
+		return nil, false, err
+	}
+	return newObject(res.Resource), true, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	raw "code.google.com/p/google-api-go-client/storage/v1"
+)
+
+// Header names for customer-supplied encryption keys (CSEK). See
+// https://cloud.google.com/storage/docs/encryption/customer-supplied-keys.
+const (
+	encryptionAlgorithmHeader = "x-goog-encryption-algorithm"
+	encryptionKeyHeader       = "x-goog-encryption-key"
+	encryptionKeySHA256Header = "x-goog-encryption-key-sha256"
+
+	sourceEncryptionAlgorithmHeader = "x-goog-copy-source-encryption-algorithm"
+	sourceEncryptionKeyHeader       = "x-goog-copy-source-encryption-key"
+	sourceEncryptionKeySHA256Header = "x-goog-copy-source-encryption-key-sha256"
+)
+
+// checkEncryptionKey reports an error if key is non-empty but isn't a
+// valid 32-byte AES-256 key.
+func checkEncryptionKey(key []byte) error {
+	if len(key) != 0 && len(key) != 32 {
+		return errors.New("storage: EncryptionKey must be a 32-byte AES-256 key")
+	}
+	return nil
+}
+
+// encryptionKeySHA256 returns the base64-encoded SHA-256 hash of key,
+// in the form GCS uses both in the x-goog-encryption-key-sha256
+// request header and the customerEncryption.keySha256 response field.
+func encryptionKeySHA256(key []byte) string {
+	sum := sha256.Sum256(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// setEncryptionHeaders sets the x-goog-encryption-* headers on h from
+// key, the customer-supplied key that should be used to encrypt (or
+// decrypt) the object's data. If key is empty, h is left untouched
+// and GCS falls back to its own (or the bucket's default) encryption.
+func setEncryptionHeaders(h http.Header, key []byte) {
+	if len(key) == 0 {
+		return
+	}
+	h.Set(encryptionAlgorithmHeader, "AES256")
+	h.Set(encryptionKeyHeader, base64.StdEncoding.EncodeToString(key))
+	h.Set(encryptionKeySHA256Header, encryptionKeySHA256(key))
+}
+
+// setSourceEncryptionHeaders sets the x-goog-copy-source-encryption-*
+// headers on h from key, the customer-supplied key that was used to
+// encrypt the source object of a Copy or Rewrite.
+func setSourceEncryptionHeaders(h http.Header, key []byte) {
+	if len(key) == 0 {
+		return
+	}
+	h.Set(sourceEncryptionAlgorithmHeader, "AES256")
+	h.Set(sourceEncryptionKeyHeader, base64.StdEncoding.EncodeToString(key))
+	h.Set(sourceEncryptionKeySHA256Header, encryptionKeySHA256(key))
+}
+
+// checkEncryptionResult reports an error if key is non-empty but ce,
+// the customerEncryption field of the object GCS reports back,
+// doesn't match it. This catches the write (or read) having been
+// silently downgraded to unencrypted or encrypted with the wrong key.
+func checkEncryptionResult(ce *raw.ObjectCustomerEncryption, key []byte) error {
+	if len(key) == 0 {
+		return nil
+	}
+	if ce == nil || ce.KeySha256 != encryptionKeySHA256(key) {
+		return fmt.Errorf("storage: object was not encrypted with the provided EncryptionKey")
+	}
+	return nil
+}
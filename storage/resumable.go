@@ -0,0 +1,156 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file implements the low-level pieces of the resumable upload
+// protocol (https://cloud.google.com/storage/docs/resumable-uploads-xml)
+// that the generated API client does not expose: initiating a session,
+// PUTting an individual chunk with a Content-Range, and querying a
+// session for the last byte it has committed so an interrupted chunk
+// can be resumed instead of restarted.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	raw "code.google.com/p/google-api-go-client/storage/v1"
+	"golang.org/x/net/context"
+)
+
+const resumableUploadBase = "https://www.googleapis.com/upload/storage/v1/b"
+
+// resumablePreconditions carries the optional generation preconditions
+// that gate whether a resumable session is allowed to start.
+type resumablePreconditions struct {
+	ifGenerationMatch     int64
+	ifMetagenerationMatch int64
+}
+
+// startResumableSession initiates a resumable upload for obj in
+// bucket and returns the session URI the client should PUT chunks to.
+// If key is non-empty, the session is initiated with the
+// x-goog-encryption-* headers so the object is encrypted with it.
+func startResumableSession(ctx context.Context, bucket string, obj *raw.Object, pre resumablePreconditions, key []byte) (string, error) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{"uploadType": {"resumable"}}
+	switch {
+	case pre.ifGenerationMatch < 0:
+		// GCS expresses "the object must not exist" as ifGenerationMatch=0.
+		q.Set("ifGenerationMatch", "0")
+	case pre.ifGenerationMatch > 0:
+		q.Set("ifGenerationMatch", strconv.FormatInt(pre.ifGenerationMatch, 10))
+	}
+	if pre.ifMetagenerationMatch != 0 {
+		q.Set("ifMetagenerationMatch", strconv.FormatInt(pre.ifMetagenerationMatch, 10))
+	}
+	u := fmt.Sprintf("%s/%s/o?%s", resumableUploadBase, url.QueryEscape(bucket), q.Encode())
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", obj.ContentType)
+	setEncryptionHeaders(req.Header, key)
+	resp, err := httpClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", &statusError{code: resp.StatusCode, msg: string(body)}
+	}
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return "", fmt.Errorf("storage: resumable session response missing Location header")
+	}
+	return loc, nil
+}
+
+// putChunk PUTs chunk to the resumable session at uri with the given
+// Content-Range header, returning the finished raw.Object once the
+// session reports the upload as complete (status 200 or 201).
+func putChunk(ctx context.Context, uri string, chunk []byte, contentRange string) (*raw.Object, error) {
+	req, err := http.NewRequest("PUT", uri, bytes.NewReader(chunk))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Range", contentRange)
+	req.ContentLength = int64(len(chunk))
+	resp, err := httpClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		var obj raw.Object
+		if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+			return nil, err
+		}
+		return &obj, nil
+	case 308: // Resume Incomplete: the chunk was accepted, more data expected.
+		return nil, nil
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, &statusError{code: resp.StatusCode, msg: string(body)}
+	}
+}
+
+// queryResumableOffset asks the session how many bytes it has
+// committed so far, so a chunk that failed partway through can be
+// resumed from the right offset instead of restarted. A response with
+// no Range header means the session hasn't committed any bytes yet,
+// so 0 is returned in that case.
+func queryResumableOffset(ctx context.Context, uri string) (int64, error) {
+	req, err := http.NewRequest("PUT", uri, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Range", "bytes */*")
+	req.ContentLength = 0
+	resp, err := httpClient(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 308 && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return 0, &statusError{code: resp.StatusCode, msg: string(body)}
+	}
+	r := resp.Header.Get("Range")
+	if r == "" {
+		return 0, nil
+	}
+	// Range is of the form "bytes=0-N"; N is the last committed byte.
+	parts := strings.SplitN(r, "-", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("storage: unexpected Range header %q", r)
+	}
+	last, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return last + 1, nil
+}
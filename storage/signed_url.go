@@ -0,0 +1,166 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignedURLOptions configures a call to SignedURL.
+type SignedURLOptions struct {
+	// GoogleAccessID is the Google service account email address that
+	// owns PrivateKey.
+	GoogleAccessID string
+
+	// PrivateKey is the PEM-encoded RSA private key belonging to
+	// GoogleAccessID, used to sign the URL.
+	PrivateKey []byte
+
+	// Method is the HTTP method that will be used with the signed
+	// URL, one of "GET", "PUT", "DELETE", "HEAD".
+	Method string
+
+	// Expires is the time after which the signed URL stops working.
+	Expires time.Time
+
+	// ContentType, if non-empty, must match the Content-Type header
+	// sent by the client using the signed URL.
+	ContentType string
+
+	// Headers is the list of "x-goog-" prefixed extension headers the
+	// client using the signed URL will send, in their entirety
+	// (e.g. "x-goog-meta-foo:bar"). They become part of the signature
+	// and the client must send exactly these headers.
+	Headers []string
+
+	// MD5, if non-empty, is the base64-encoded MD5 checksum the
+	// client must send as the Content-MD5 header.
+	MD5 []byte
+}
+
+// SignedURL returns a URL for the named object in bucket that grants
+// the holder the permission to perform the action specified in opts
+// until opts.Expires, without further authentication. See
+// https://cloud.google.com/storage/docs/access-control/signed-urls
+// for the URL's semantics.
+func SignedURL(bucket, name string, opts *SignedURLOptions) (string, error) {
+	if opts == nil {
+		return "", errors.New("storage: opts must not be nil")
+	}
+	if opts.GoogleAccessID == "" {
+		return "", errors.New("storage: opts.GoogleAccessID must be set")
+	}
+	if opts.Method == "" {
+		return "", errors.New("storage: opts.Method must be set")
+	}
+	if opts.Expires.IsZero() {
+		return "", errors.New("storage: opts.Expires must be set")
+	}
+
+	key, err := parsePrivateKey(opts.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	expires := opts.Expires.Unix()
+	expiresStr := strconv.FormatInt(expires, 10)
+	stringToSign := strings.Join([]string{
+		opts.Method,
+		string(opts.MD5),
+		opts.ContentType,
+		expiresStr,
+		canonicalizeExtensionHeaders(opts.Headers),
+		canonicalizedResource(bucket, name),
+	}, "\n")
+
+	sum := sha256.Sum256([]byte(stringToSign))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", fmt.Errorf("storage: could not sign URL: %v", err)
+	}
+
+	u := &url.URL{
+		Scheme: "https",
+		Host:   "storage.googleapis.com",
+		Path:   fmt.Sprintf("/%s/%s", bucket, name),
+	}
+	q := url.Values{
+		"GoogleAccessID": {opts.GoogleAccessID},
+		"Expires":        {expiresStr},
+		"Signature":      {base64.StdEncoding.EncodeToString(sig)},
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// parsePrivateKey extracts the RSA private key from a PEM block,
+// accepting both PKCS#1 ("RSA PRIVATE KEY") and PKCS#8 ("PRIVATE KEY")
+// encodings, the two forms Google service account JSON keys are
+// distributed in.
+func parsePrivateKey(pemKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("storage: opts.PrivateKey is not a valid PEM-encoded key")
+	}
+	parsedKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsedKey8, err8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err8 != nil {
+			return nil, fmt.Errorf("storage: could not parse opts.PrivateKey: %v", err)
+		}
+		key, ok := parsedKey8.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("storage: opts.PrivateKey is not an RSA key")
+		}
+		return key, nil
+	}
+	return parsedKey, nil
+}
+
+// canonicalizedResource returns the "/bucket/object" resource path
+// that the V2 signing algorithm includes in its string-to-sign.
+func canonicalizedResource(bucket, name string) string {
+	return fmt.Sprintf("/%s/%s", bucket, name)
+}
+
+// canonicalizeExtensionHeaders lower-cases, trims and collapses
+// whitespace in each header, sorts them, and joins them with "\n", as
+// the V2 signing algorithm requires for its
+// CanonicalizedExtensionHeaders component. It returns "" if headers
+// is empty, matching the algorithm's empty-line placeholder.
+func canonicalizeExtensionHeaders(headers []string) string {
+	if len(headers) == 0 {
+		return ""
+	}
+	norm := make([]string, len(headers))
+	for i, h := range headers {
+		norm[i] = strings.Join(strings.Fields(strings.ToLower(h)), " ")
+	}
+	sort.Strings(norm)
+	return strings.Join(norm, "\n")
+}
@@ -15,7 +15,7 @@
 package storage
 
 import (
-	"io"
+	"encoding/base64"
 	"time"
 
 	raw "code.google.com/p/google-api-go-client/storage/v1"
@@ -56,6 +56,137 @@ type Bucket struct {
 	// Created is the creation time of the bucket.
 	// Read-only.
 	Created time.Time `json:"timeCreated,omitempty"`
+
+	// Lifecycle configures automatic deletion and storage class
+	// transitions for the objects in the bucket.
+	Lifecycle Lifecycle `json:"lifecycle,omitempty"`
+}
+
+// Lifecycle represents a bucket's lifecycle configuration, consisting
+// of the set of rules applied on each call to evaluate the bucket's
+// objects against them.
+type Lifecycle struct {
+	// Rules is the list of lifecycle rules to apply to the bucket.
+	Rules []LifecycleRule
+}
+
+// LifecycleRule describes an action to take when the Condition is met.
+type LifecycleRule struct {
+	// Action is the action to take when all of the associated conditions
+	// are met.
+	Action LifecycleAction
+
+	// Condition is the set of criteria that trigger the associated
+	// action.
+	Condition LifecycleCondition
+}
+
+// LifecycleAction describes an action to take on a matching object.
+type LifecycleAction struct {
+	// Type is the type of action to take, currently either "Delete" or
+	// "SetStorageClass".
+	Type string
+
+	// StorageClass is the storage class to set on matching objects if
+	// the Action is "SetStorageClass".
+	StorageClass string
+}
+
+// Using the constants below for LifecycleAction.Type avoids misspellings.
+const (
+	// DeleteAction causes objects that satisfy a LifecycleRule's
+	// Condition to be deleted.
+	DeleteAction = "Delete"
+
+	// SetStorageClassAction changes the storage class of objects that
+	// satisfy the rule's Condition to the StorageClass configured on
+	// the LifecycleAction.
+	SetStorageClassAction = "SetStorageClass"
+)
+
+// LifecycleCondition is a set of criteria used to describe when a
+// lifecycle action should be taken.
+type LifecycleCondition struct {
+	// AgeInDays is the age of the object in days.
+	AgeInDays int64
+
+	// CreatedBefore is the time the object was created before. This
+	// condition is satisfied when an object is created before midnight
+	// of the specified date in UTC.
+	CreatedBefore time.Time
+
+	// IsLive, if non-nil, restricts the condition to live (true) or
+	// archived (false) objects. Relevant only for versioned objects. A
+	// nil IsLive matches all objects regardless of liveness.
+	IsLive *bool
+
+	// MatchesStorageClass is the list of storage classes for which this
+	// condition matches. Values include "STANDARD",
+	// "DURABLE_REDUCED_AVAILABILITY", and "NEARLINE".
+	MatchesStorageClass []string
+
+	// NumNewerVersions is the number of newer versions of an object
+	// that must exist for this condition to be satisfied.
+	NumNewerVersions int64
+}
+
+// toRawLifecycle always returns a non-nil *raw.BucketLifecycle, even
+// when l has no rules, so that BucketHandle.Update can send an
+// explicit empty lifecycle to clear a bucket's existing rules - a nil
+// Lifecycle field on the Patch body would instead be omitted and
+// leave the bucket's lifecycle untouched.
+func toRawLifecycle(l Lifecycle) *raw.BucketLifecycle {
+	rl := &raw.BucketLifecycle{}
+	for _, r := range l.Rules {
+		rr := &raw.BucketLifecycleRule{
+			Action: &raw.BucketLifecycleRuleAction{
+				Type:         r.Action.Type,
+				StorageClass: r.Action.StorageClass,
+			},
+			Condition: &raw.BucketLifecycleRuleCondition{
+				Age:                 r.Condition.AgeInDays,
+				NumNewerVersions:    r.Condition.NumNewerVersions,
+				MatchesStorageClass: r.Condition.MatchesStorageClass,
+			},
+		}
+		if r.Condition.IsLive != nil {
+			rr.Condition.IsLive = r.Condition.IsLive
+		}
+		if !r.Condition.CreatedBefore.IsZero() {
+			rr.Condition.CreatedBefore = r.Condition.CreatedBefore.Format("2006-01-02")
+		}
+		rl.Rule = append(rl.Rule, rr)
+	}
+	return rl
+}
+
+func toLifecycle(rl *raw.BucketLifecycle) Lifecycle {
+	var l Lifecycle
+	if rl == nil {
+		return l
+	}
+	for _, rr := range rl.Rule {
+		r := LifecycleRule{}
+		if rr.Action != nil {
+			r.Action = LifecycleAction{
+				Type:         rr.Action.Type,
+				StorageClass: rr.Action.StorageClass,
+			}
+		}
+		if rr.Condition != nil {
+			r.Condition = LifecycleCondition{
+				AgeInDays:           rr.Condition.Age,
+				NumNewerVersions:    rr.Condition.NumNewerVersions,
+				MatchesStorageClass: rr.Condition.MatchesStorageClass,
+				IsLive:              rr.Condition.IsLive,
+			}
+			if rr.Condition.CreatedBefore != "" {
+				r.Condition.CreatedBefore, _ = time.Parse("2006-01-02", rr.Condition.CreatedBefore)
+			}
+		}
+		l.Rules = append(l.Rules, r)
+	}
+	return l
 }
 
 func newBucket(b *raw.Bucket) *Bucket {
@@ -68,6 +199,7 @@ func newBucket(b *raw.Bucket) *Bucket {
 		Metageneration: b.Metageneration,
 		StorageClass:   b.StorageClass,
 		Created:        convertTime(b.TimeCreated),
+		Lifecycle:      toLifecycle(b.Lifecycle),
 	}
 	acl := make([]ACLRule, len(b.Acl))
 	for i, rule := range b.Acl {
@@ -137,6 +269,12 @@ type Object struct {
 	// It can be nil if no metadata is provided.
 	Metadata map[string]string `json:"metadata,omitempty"`
 
+	// EncryptionKey is a 32-byte AES-256 customer-supplied key used to
+	// encrypt the object's data instead of a Google-managed key. The
+	// same key must be supplied again to read or further modify the
+	// object; GCS keeps no way to recover the data without it.
+	EncryptionKey []byte
+
 	// Generation is the generation version of the object's content.
 	// Read-only.
 	Generation int64 `json:"generation,omitempty"`
@@ -182,6 +320,7 @@ func (o *Object) toRawObject() *raw.Object {
 		ContentLanguage: o.ContentLanguage,
 		Acl:             acl,
 		Metadata:        o.Metadata,
+		StorageClass:    o.StorageClass,
 	}
 }
 
@@ -195,6 +334,20 @@ func convertTime(t string) time.Time {
 	return r
 }
 
+// decodeBase64 decodes s, which GCS returns as the base64 encoding of
+// a binary checksum (md5Hash, crc32c). If s is empty or malformed,
+// the zero-value nil slice is silently returned.
+func decodeBase64(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
 func newObject(o *raw.Object) *Object {
 	if o == nil {
 		return nil
@@ -216,8 +369,8 @@ func newObject(o *raw.Object) *Object {
 		Owner:           Owner{Entity: o.Owner.Entity},
 		ContentEncoding: o.ContentEncoding,
 		Size:            o.Size,
-		MD5:             []byte(o.Md5Hash),
-		CRC32C:          []byte(o.Crc32c),
+		MD5:             decodeBase64(o.Md5Hash),
+		CRC32C:          decodeBase64(o.Crc32c),
 		MediaLink:       o.MediaLink,
 		Metadata:        o.Metadata,
 		Generation:      o.Generation,
@@ -278,78 +431,15 @@ type Objects struct {
 	Prefixes []string
 }
 
-// contentTyper implements ContentTyper to enable an
-// io.ReadCloser to specify its MIME type.
-type contentTyper struct {
-	io.ReadCloser
-	t string
-}
-
-func (c *contentTyper) ContentType() string {
-	return c.t
-}
-
 // newObjectWriter returns a new ObjectWriter that writes to
 // the file that is specified by info.Bucket and info.Name.
 // Metadata changes are also reflected on the remote object
 // entity, read-only fields are ignored during the write operation.
 func newObjectWriter(ctx context.Context, info *Object) *ObjectWriter {
-	w := &ObjectWriter{
-		ctx:  ctx,
-		done: make(chan bool),
+	return &ObjectWriter{
+		ctx:       ctx,
+		info:      info,
+		ChunkSize: defaultChunkSize,
+		done:      make(chan bool),
 	}
-	pr, pw := io.Pipe()
-	w.rc = &contentTyper{pr, info.ContentType}
-	w.pw = pw
-	go func() {
-		resp, err := rawService(ctx).Objects.Insert(
-			info.Bucket, info.toRawObject()).Media(w.rc).Do()
-		w.err = err
-		if err == nil {
-			w.obj = newObject(resp)
-		}
-		close(w.done)
-	}()
-	return w
-}
-
-// ObjectWriter is an io.WriteCloser that opens a connection
-// to update the metadata and file contents of a GCS object.
-type ObjectWriter struct {
-	ctx context.Context
-
-	rc io.ReadCloser
-	pw *io.PipeWriter
-
-	done chan bool
-	obj  *Object
-	err  error
-}
-
-// Write writes len(p) bytes to the object. It returns the number
-// of the bytes written, or an error if there is a problem occured
-// during the write. It's a blocking operation, and will not return
-// until the bytes are written to the underlying socket.
-func (w *ObjectWriter) Write(p []byte) (n int, err error) {
-	if w.err != nil {
-		return 0, w.err
-	}
-	return w.pw.Write(p)
-}
-
-// Close closes the writer and cleans up other resources
-// used by the writer.
-func (w *ObjectWriter) Close() error {
-	if w.err != nil {
-		return w.err
-	}
-	w.rc.Close()
-	return w.pw.Close()
-}
-
-// Object returns the object information. It will block until
-// the write operation is complete.
-func (w *ObjectWriter) Object() (*Object, error) {
-	<-w.done
-	return w.obj, w.err
 }
@@ -0,0 +1,391 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// defaultChunkSize is the chunk size used when ObjectWriter.ChunkSize
+// is left at its zero value.
+const defaultChunkSize = 8 << 20 // 8 MiB
+
+// chunkAlignment is the granularity GCS requires resumable upload
+// chunks (other than the last one) to be a multiple of.
+const chunkAlignment = 256 << 10 // 256 KiB
+
+// maxChunkRetries is the number of times a single chunk is retried
+// after a retriable (5xx or 408) response before the upload gives up.
+const maxChunkRetries = 5
+
+// crc32cTable is the Castagnoli CRC32C table GCS uses for its crc32c
+// object field.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksumMismatch is returned by ObjectWriter.Object when the
+// CRC32C or MD5 digest GCS computed from the bytes it received
+// doesn't match the one computed locally while writing, meaning the
+// object was corrupted in transit.
+var ErrChecksumMismatch = errors.New("storage: object checksum mismatch")
+
+// ObjectWriter is an io.WriteCloser that opens a resumable upload
+// session and streams the object's contents to it in chunks, retrying
+// individual chunks that fail with a transient error. Metadata changes
+// are reflected on the remote object entity; read-only fields are
+// ignored during the write operation.
+type ObjectWriter struct {
+	ctx  context.Context
+	info *Object
+
+	// ChunkSize is the number of bytes buffered and sent to GCS per
+	// resumable upload request. It must be a multiple of 256 KiB. If
+	// zero, defaultChunkSize is used.
+	ChunkSize int
+
+	// ProgressFunc, if non-nil, is called after each chunk is
+	// successfully committed with the total number of bytes written
+	// so far.
+	ProgressFunc func(bytesWritten int64)
+
+	// IfGenerationMatch, if non-zero, makes the upload fail unless the
+	// object's current generation matches this value. Use -1 to
+	// require that the object does not exist.
+	IfGenerationMatch int64
+
+	// IfMetagenerationMatch, if non-zero, makes the upload fail unless
+	// the object's current metageneration matches this value.
+	IfMetagenerationMatch int64
+
+	// SendCRC32C, if true, makes the writer compute the object's
+	// CRC32C checksum (Castagnoli polynomial) as it's written and
+	// declare it in the upload so GCS rejects the object if the bytes
+	// it received don't hash to the same value. It also makes Object
+	// compare GCS's reported checksum against the one computed here,
+	// returning ErrChecksumMismatch on a mismatch.
+	//
+	// Because the checksum must be declared before the upload session
+	// can be opened, setting SendCRC32C or SendMD5 changes how writes
+	// are handled: instead of streaming chunks to GCS as Write is
+	// called, bytes are spooled to a temporary file on local disk
+	// (not held in memory) and the upload itself - session open plus
+	// every chunk - doesn't start until Close. This still bounds
+	// memory for multi-GB objects, but it gives up the streaming-while-
+	// writing behavior of the unhashed path and needs scratch disk space
+	// equal to the object's size.
+	SendCRC32C bool
+
+	// SendMD5 is the MD5 analogue of SendCRC32C.
+	SendMD5 bool
+
+	sessionURI string
+	buf        bytes.Buffer
+	spool      *os.File // holds the object instead of buf when SendCRC32C or SendMD5 is set
+	written    int64    // bytes committed to the session so far
+	started    bool
+
+	crc32c hash.Hash32
+	md5    hash.Hash
+
+	done chan bool
+	obj  *Object
+	err  error
+}
+
+// Write buffers p and flushes complete chunks to the resumable upload
+// session. It's a blocking operation and will not return until any
+// full chunks accumulated are committed. If SendCRC32C or SendMD5 is
+// set, p is hashed and spooled to local disk instead; the upload
+// itself doesn't start until Close, since the checksum must be
+// declared when the session opens.
+func (w *ObjectWriter) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.ensureHashes()
+	if w.crc32c != nil {
+		w.crc32c.Write(p)
+	}
+	if w.md5 != nil {
+		w.md5.Write(p)
+	}
+	if w.hashing() {
+		if w.spool == nil {
+			w.spool, w.err = ioutil.TempFile("", "gcs-upload-")
+			if w.err != nil {
+				return 0, w.err
+			}
+		}
+		n, w.err = w.spool.Write(p)
+		return n, w.err
+	}
+	if !w.started {
+		if err := w.start(); err != nil {
+			w.err = err
+			return 0, err
+		}
+		w.started = true
+	}
+	n, _ = w.buf.Write(p)
+	chunkSize := w.chunkSize()
+	for w.buf.Len() >= chunkSize {
+		if err := w.sendChunk(w.buf.Next(chunkSize), false); err != nil {
+			w.err = err
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close flushes any remaining buffered bytes as the final chunk,
+// completing the upload, and cleans up resources used by the writer.
+// If SendCRC32C or SendMD5 spooled the object to disk, this is where
+// the upload session opens and every chunk is sent.
+func (w *ObjectWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.ensureHashes()
+	if w.spool != nil {
+		defer func() {
+			name := w.spool.Name()
+			w.spool.Close()
+			os.Remove(name)
+		}()
+		if _, err := w.spool.Seek(0, os.SEEK_SET); err != nil {
+			w.err = err
+			close(w.done)
+			return w.err
+		}
+	}
+	if !w.started {
+		if err := w.start(); err != nil {
+			w.err = err
+			return err
+		}
+	}
+	chunkSize := w.chunkSize()
+	if w.spool != nil {
+		chunk := make([]byte, chunkSize)
+		for w.err == nil {
+			nRead, rerr := io.ReadFull(w.spool, chunk)
+			if rerr == io.ErrUnexpectedEOF || rerr == io.EOF {
+				w.err = w.sendChunk(chunk[:nRead], true)
+				break
+			}
+			if rerr != nil {
+				w.err = rerr
+				break
+			}
+			w.err = w.sendChunk(chunk[:nRead], false)
+		}
+		close(w.done)
+		return w.err
+	}
+	for w.err == nil && w.buf.Len() > chunkSize {
+		w.err = w.sendChunk(w.buf.Next(chunkSize), false)
+	}
+	if w.err == nil {
+		rest := w.buf.Next(w.buf.Len())
+		w.err = w.sendChunk(rest, true)
+	}
+	close(w.done)
+	return w.err
+}
+
+// Object returns the object information. It will block until the
+// write operation is complete. If SendCRC32C or SendMD5 is set, it
+// also verifies GCS's reported checksum against the one computed
+// locally, returning ErrChecksumMismatch if they disagree.
+func (w *ObjectWriter) Object() (*Object, error) {
+	<-w.done
+	if w.err == nil {
+		w.err = w.verifyChecksums()
+	}
+	return w.obj, w.err
+}
+
+// hashing reports whether the writer needs to compute a checksum as
+// bytes are written.
+func (w *ObjectWriter) hashing() bool {
+	return w.SendCRC32C || w.SendMD5
+}
+
+// ensureHashes lazily creates the hash.Hash values backing
+// SendCRC32C/SendMD5 the first time they're needed.
+func (w *ObjectWriter) ensureHashes() {
+	if w.SendCRC32C && w.crc32c == nil {
+		w.crc32c = crc32.New(crc32cTable)
+	}
+	if w.SendMD5 && w.md5 == nil {
+		w.md5 = md5.New()
+	}
+}
+
+// verifyChecksums compares the checksums GCS reported for w.obj
+// against the ones computed locally while writing.
+func (w *ObjectWriter) verifyChecksums() error {
+	if w.crc32c != nil && !bytes.Equal(w.obj.CRC32C, w.crc32c.Sum(nil)) {
+		return ErrChecksumMismatch
+	}
+	if w.md5 != nil && !bytes.Equal(w.obj.MD5, w.md5.Sum(nil)) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+func (w *ObjectWriter) chunkSize() int {
+	if w.ChunkSize <= 0 {
+		return defaultChunkSize
+	}
+	return w.ChunkSize
+}
+
+// start opens the resumable upload session and records its session URI.
+func (w *ObjectWriter) start() error {
+	if w.chunkSize()%chunkAlignment != 0 {
+		return errors.New("storage: ChunkSize must be a multiple of 256 KiB")
+	}
+	if err := checkEncryptionKey(w.info.EncryptionKey); err != nil {
+		return err
+	}
+	obj := w.info.toRawObject()
+	if w.crc32c != nil {
+		obj.Crc32c = base64.StdEncoding.EncodeToString(w.crc32c.Sum(nil))
+	}
+	if w.md5 != nil {
+		obj.Md5Hash = base64.StdEncoding.EncodeToString(w.md5.Sum(nil))
+	}
+	uri, err := startResumableSession(w.ctx, w.info.Bucket, obj, resumablePreconditions{
+		ifGenerationMatch:     w.IfGenerationMatch,
+		ifMetagenerationMatch: w.IfMetagenerationMatch,
+	}, w.info.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	w.sessionURI = uri
+	return nil
+}
+
+// sendChunk PUTs chunk to the resumable session, retrying on
+// transient errors by querying the session for the last committed
+// byte and resuming from there. If final is true, chunk is the last
+// chunk of the object and the total size is declared in the
+// Content-Range header.
+func (w *ObjectWriter) sendChunk(chunk []byte, final bool) error {
+	start := w.written
+	for attempt := 0; ; attempt++ {
+		rangeHeader := chunkRangeHeader(start, chunk, final, start)
+		resp, err := putChunk(w.ctx, w.sessionURI, chunk, rangeHeader)
+		if err == nil {
+			w.written = start + int64(len(chunk))
+			if w.ProgressFunc != nil {
+				w.ProgressFunc(w.written)
+			}
+			if final {
+				if resp == nil {
+					return errors.New("storage: resumable upload reported Resume Incomplete on the final chunk")
+				}
+				if err := checkEncryptionResult(resp.CustomerEncryption, w.info.EncryptionKey); err != nil {
+					return err
+				}
+				w.obj = newObject(resp)
+			}
+			return nil
+		}
+		if !isRetriableChunkError(err) || attempt >= maxChunkRetries {
+			return err
+		}
+		committed, qerr := queryResumableOffset(w.ctx, w.sessionURI)
+		if qerr != nil {
+			return qerr
+		}
+		chunk, start = resumeFrom(chunk, start, committed)
+		time.Sleep(backoff(attempt))
+	}
+}
+
+// chunkRangeHeader builds the Content-Range header for a chunk starting
+// at start, final indicating whether it's the object's last chunk (in
+// which case the total size, written so far plus this chunk, is
+// declared instead of "*"). A zero-length final chunk - sent when the
+// object's size is an exact multiple of the chunk size - uses the
+// "bytes */total" form with no byte range, since GCS rejects an empty
+// range such as "bytes N-(N-1)".
+func chunkRangeHeader(start int64, chunk []byte, final bool, written int64) string {
+	if len(chunk) == 0 && final {
+		return fmt.Sprintf("bytes */%d", written)
+	}
+	total := "*"
+	if final {
+		total = fmt.Sprintf("%d", written+int64(len(chunk)))
+	}
+	end := start + int64(len(chunk)) - 1
+	return fmt.Sprintf("bytes %d-%d/%s", start, end, total)
+}
+
+// resumeFrom trims chunk down to the bytes still needed to reach
+// committed, GCS's last confirmed byte offset, given that chunk
+// currently starts at start. If committed is not past start, nothing
+// has been accepted since the last attempt and chunk is returned
+// unchanged.
+func resumeFrom(chunk []byte, start, committed int64) ([]byte, int64) {
+	if committed > start {
+		return chunk[committed-start:], committed
+	}
+	return chunk, start
+}
+
+// isRetriableChunkError reports whether err represents a transient
+// failure (5xx or 408) that warrants retrying the chunk.
+func isRetriableChunkError(err error) bool {
+	if se, ok := err.(*statusError); ok {
+		return se.code >= 500 || se.code == http.StatusRequestTimeout
+	}
+	return false
+}
+
+// statusError wraps a non-2xx HTTP response from the resumable upload
+// endpoint.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("storage: upload failed with status %d: %s", e.code, e.msg)
+}
+
+func backoff(attempt int) time.Duration {
+	d := time.Duration(1<<uint(attempt)) * 100 * time.Millisecond
+	if d > 30*time.Second {
+		d = 30 * time.Second
+	}
+	return d
+}
+
+var _ io.WriteCloser = (*ObjectWriter)(nil)
@@ -0,0 +1,119 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import "testing"
+
+func TestChunkRangeHeader(t *testing.T) {
+	tests := []struct {
+		desc    string
+		start   int64
+		chunk   []byte
+		final   bool
+		written int64
+		want    string
+	}{
+		{
+			desc:    "middle chunk, unknown total",
+			start:   0,
+			chunk:   make([]byte, 8),
+			final:   false,
+			written: 0,
+			want:    "bytes 0-7/*",
+		},
+		{
+			desc:    "middle chunk resumed partway through the object",
+			start:   16,
+			chunk:   make([]byte, 8),
+			final:   false,
+			written: 16,
+			want:    "bytes 16-23/*",
+		},
+		{
+			desc:    "final chunk declares the total size",
+			start:   16,
+			chunk:   make([]byte, 4),
+			final:   true,
+			written: 16,
+			want:    "bytes 16-19/20",
+		},
+		{
+			desc:    "zero-length final chunk has no byte range",
+			start:   20,
+			chunk:   nil,
+			final:   true,
+			written: 20,
+			want:    "bytes */20",
+		},
+	}
+	for _, tt := range tests {
+		got := chunkRangeHeader(tt.start, tt.chunk, tt.final, tt.written)
+		if got != tt.want {
+			t.Errorf("%s: chunkRangeHeader(%d, len=%d, %v, %d) = %q, want %q",
+				tt.desc, tt.start, len(tt.chunk), tt.final, tt.written, got, tt.want)
+		}
+	}
+}
+
+func TestResumeFrom(t *testing.T) {
+	tests := []struct {
+		desc          string
+		chunk         []byte
+		start         int64
+		committed     int64
+		wantRemaining int
+		wantStart     int64
+	}{
+		{
+			desc:          "nothing committed since last attempt",
+			chunk:         []byte("0123456789"),
+			start:         10,
+			committed:     10,
+			wantRemaining: 10,
+			wantStart:     10,
+		},
+		{
+			desc:          "some bytes committed before the failure",
+			chunk:         []byte("0123456789"),
+			start:         10,
+			committed:     14,
+			wantRemaining: 6,
+			wantStart:     14,
+		},
+		{
+			desc:          "the whole chunk was committed",
+			chunk:         []byte("0123456789"),
+			start:         10,
+			committed:     20,
+			wantRemaining: 0,
+			wantStart:     20,
+		},
+		{
+			desc:          "committed offset stale or behind start is ignored",
+			chunk:         []byte("0123456789"),
+			start:         10,
+			committed:     5,
+			wantRemaining: 10,
+			wantStart:     10,
+		},
+	}
+	for _, tt := range tests {
+		gotChunk, gotStart := resumeFrom(tt.chunk, tt.start, tt.committed)
+		if len(gotChunk) != tt.wantRemaining || gotStart != tt.wantStart {
+			t.Errorf("%s: resumeFrom(chunk, %d, %d) = (len %d, %d), want (len %d, %d)",
+				tt.desc, tt.start, tt.committed, len(gotChunk), gotStart, tt.wantRemaining, tt.wantStart)
+		}
+	}
+}